@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	"github.com/joho/godotenv"
@@ -22,13 +24,76 @@ type Configs struct{
 	JWT_SIGNING_KEY string
 	GOOGLE_CLIENT_ID string
 	GOOGLE_CLIENT_SECRET string
+	OAUTH_EXCHANGE_TIMEOUT time.Duration
+	SPOTIFY_HIDDEN_PLAYLISTS []string
+	ADMIN_SPOTIFY_IDS []string
+}
+
+// Version is the running build, set with -ldflags "-X github.com/nnajiabraham/spotube/config.Version=<version>"
+var Version = "dev"
+
+// defaultOauthExchangeTimeout bounds each oauth code exchange when OAUTH_EXCHANGE_TIMEOUT isn't set
+const defaultOauthExchangeTimeout = 10 * time.Second
+
+// Redacted returns the configs keyed by env name with secrets masked, safe to share for diagnostics
+func (c *Configs) Redacted() map[string]string {
+	mask := func(value string) string {
+		if value == "" {
+			return ""
+		}
+		return "********"
+	}
+
+	return map[string]string{
+		"SPOTIFY_ID": c.SPOTIFY_ID,
+		"SPOTIFY_SECRET": mask(c.SPOTIFY_SECRET),
+		"TOKEN_STATE": mask(c.TOKEN_STATE),
+		"JWT_SIGNING_KEY": mask(c.JWT_SIGNING_KEY),
+		"GOOGLE_CLIENT_ID": c.GOOGLE_CLIENT_ID,
+		"GOOGLE_CLIENT_SECRET": mask(c.GOOGLE_CLIENT_SECRET),
+		"OAUTH_EXCHANGE_TIMEOUT": c.OAUTH_EXCHANGE_TIMEOUT.String(),
+		"SPOTIFY_HIDDEN_PLAYLISTS": strings.Join(c.SPOTIFY_HIDDEN_PLAYLISTS, ","),
+		"ADMIN_SPOTIFY_IDS": strings.Join(c.ADMIN_SPOTIFY_IDS, ","),
+	}
+}
+
+// missingRequired lists the env names of required configs that are empty
+func (c *Configs) missingRequired() []string {
+	required := []struct {
+		name  string
+		value string
+	}{
+		{"SPOTIFY_ID", c.SPOTIFY_ID},
+		{"SPOTIFY_SECRET", c.SPOTIFY_SECRET},
+		{"TOKEN_STATE", c.TOKEN_STATE},
+		{"JWT_SIGNING_KEY", c.JWT_SIGNING_KEY},
+	}
+
+	missing := []string{}
+	for _, config := range required {
+		if strings.TrimSpace(config.value) == "" {
+			missing = append(missing, config.name)
+		}
+	}
+	return missing
+}
+
+// splitList splits a comma separated env value, dropping blank entries
+func splitList(value string) []string {
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
 }
 
 // ReadConfig .
 func (c *AppConfig) ReadConfig() (*Configs, error) {
-	// loads values from .env into the system
-    if err := godotenv.Load(); err != nil {
-		return nil, errors.New("No .env file found missing important configs")
+	// loads values from .env into the system, the file is optional when the env vars are already set
+    if err := godotenv.Load(); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Unable to load .env file: %s", err.Error())
 	}
 	
 	config := &Configs{
@@ -38,6 +103,21 @@ func (c *AppConfig) ReadConfig() (*Configs, error) {
 		JWT_SIGNING_KEY: os.Getenv("JWT_SIGNING_KEY"),
 		GOOGLE_CLIENT_ID: os.Getenv("GOOGLE_CLIENT_ID"),
 		GOOGLE_CLIENT_SECRET: os.Getenv("GOOGLE_CLIENT_SECRET"),
+		OAUTH_EXCHANGE_TIMEOUT: defaultOauthExchangeTimeout,
+		SPOTIFY_HIDDEN_PLAYLISTS: splitList(os.Getenv("SPOTIFY_HIDDEN_PLAYLISTS")),
+		ADMIN_SPOTIFY_IDS: splitList(os.Getenv("ADMIN_SPOTIFY_IDS")),
+	}
+
+	if timeout := os.Getenv("OAUTH_EXCHANGE_TIMEOUT"); timeout != "" {
+		parsedTimeout, err := time.ParseDuration(timeout)
+		if err != nil || parsedTimeout <= 0 {
+			return nil, fmt.Errorf("Invalid OAUTH_EXCHANGE_TIMEOUT %q, expected a duration like 10s", timeout)
+		}
+		config.OAUTH_EXCHANGE_TIMEOUT = parsedTimeout
+	}
+
+	if missing := config.missingRequired(); len(missing) > 0 {
+		return nil, errors.New("Missing important configs, set them in the environment or .env: " + strings.Join(missing, ", "))
 	}
 	
 	return config, nil
@@ -0,0 +1,209 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setEnv sets (or unsets, for empty values) env vars for a test and returns a func restoring the previous values
+func setEnv(vars map[string]string) func() {
+	previous := map[string]*string{}
+	for name, value := range vars {
+		if old, ok := os.LookupEnv(name); ok {
+			previous[name] = &old
+		} else {
+			previous[name] = nil
+		}
+
+		if value == "" {
+			os.Unsetenv(name)
+		} else {
+			os.Setenv(name, value)
+		}
+	}
+
+	return func() {
+		for name, old := range previous {
+			if old == nil {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, *old)
+			}
+		}
+	}
+}
+
+func completeEnv() map[string]string {
+	return map[string]string{
+		"SPOTIFY_ID": "spotify-client-id",
+		"SPOTIFY_SECRET": "spotify-secret",
+		"TOKEN_STATE": "state",
+		"JWT_SIGNING_KEY": "signing-key",
+		"GOOGLE_CLIENT_ID": "",
+		"GOOGLE_CLIENT_SECRET": "",
+		"OAUTH_EXCHANGE_TIMEOUT": "",
+		"SPOTIFY_HIDDEN_PLAYLISTS": "",
+		"ADMIN_SPOTIFY_IDS": "",
+	}
+}
+
+// the tests run in the config directory, which has no .env file
+func TestReadConfigWithoutEnvFile(t *testing.T) {
+	defer setEnv(completeEnv())()
+
+	configs, err := (&AppConfig{}).ReadConfig()
+	if err != nil {
+		t.Fatalf("expected configs from the environment, got error %s", err)
+	}
+	if configs.SPOTIFY_ID != "spotify-client-id" || configs.JWT_SIGNING_KEY != "signing-key" {
+		t.Errorf("unexpected configs %+v", configs)
+	}
+	if configs.OAUTH_EXCHANGE_TIMEOUT != defaultOauthExchangeTimeout {
+		t.Errorf("expected the default exchange timeout, got %s", configs.OAUTH_EXCHANGE_TIMEOUT)
+	}
+}
+
+func TestReadConfigMissingRequired(t *testing.T) {
+	cases := []struct {
+		name    string
+		env     map[string]string
+		missing []string
+	}{
+		{
+			name:    "no configs",
+			env:     map[string]string{"SPOTIFY_ID": "", "SPOTIFY_SECRET": "", "TOKEN_STATE": "", "JWT_SIGNING_KEY": ""},
+			missing: []string{"SPOTIFY_ID", "SPOTIFY_SECRET", "TOKEN_STATE", "JWT_SIGNING_KEY"},
+		},
+		{
+			name:    "partial configs",
+			env:     map[string]string{"SPOTIFY_SECRET": "", "JWT_SIGNING_KEY": ""},
+			missing: []string{"SPOTIFY_SECRET", "JWT_SIGNING_KEY"},
+		},
+		{
+			name:    "whitespace config",
+			env:     map[string]string{"TOKEN_STATE": "   "},
+			missing: []string{"TOKEN_STATE"},
+		},
+	}
+
+	for _, c := range cases {
+		env := completeEnv()
+		for key, value := range c.env {
+			env[key] = value
+		}
+		restore := setEnv(env)
+
+		_, err := (&AppConfig{}).ReadConfig()
+		restore()
+
+		if err == nil {
+			t.Errorf("%s: expected an error", c.name)
+			continue
+		}
+		if !strings.HasSuffix(err.Error(), ": "+strings.Join(c.missing, ", ")) {
+			t.Errorf("%s: expected exactly %v to be reported, got %q", c.name, c.missing, err)
+		}
+	}
+}
+
+func TestReadConfigExchangeTimeout(t *testing.T) {
+	// a zero duration marks a value that must be rejected
+	timeouts := map[string]time.Duration{
+		"30s": 30 * time.Second,
+		"1m":  time.Minute,
+		"abc": 0,
+		"10":  0,
+		"0s":  0,
+		"-5s": 0,
+	}
+
+	for timeout, expected := range timeouts {
+		env := completeEnv()
+		env["OAUTH_EXCHANGE_TIMEOUT"] = timeout
+		restore := setEnv(env)
+
+		configs, err := (&AppConfig{}).ReadConfig()
+		restore()
+
+		if expected == 0 {
+			if err == nil {
+				t.Errorf("%s: expected it to be rejected", timeout)
+			}
+			continue
+		}
+		if err != nil || configs.OAUTH_EXCHANGE_TIMEOUT != expected {
+			t.Errorf("%s: expected %s, got %v %v", timeout, expected, configs, err)
+		}
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	configs := &Configs{
+		SPOTIFY_ID: "spotify-client-id",
+		SPOTIFY_SECRET: "spotify-secret",
+		TOKEN_STATE: "state",
+		JWT_SIGNING_KEY: "signing-key",
+		GOOGLE_CLIENT_ID: "google-client-id",
+		GOOGLE_CLIENT_SECRET: "",
+		OAUTH_EXCHANGE_TIMEOUT: 15 * time.Second,
+		SPOTIFY_HIDDEN_PLAYLISTS: []string{"playlist1", "playlist2"},
+		ADMIN_SPOTIFY_IDS: []string{"admin"},
+	}
+
+	expected := map[string]string{
+		"SPOTIFY_ID": "spotify-client-id",
+		"SPOTIFY_SECRET": "********",
+		"TOKEN_STATE": "********",
+		"JWT_SIGNING_KEY": "********",
+		"GOOGLE_CLIENT_ID": "google-client-id",
+		"GOOGLE_CLIENT_SECRET": "",
+		"OAUTH_EXCHANGE_TIMEOUT": "15s",
+		"SPOTIFY_HIDDEN_PLAYLISTS": "playlist1,playlist2",
+		"ADMIN_SPOTIFY_IDS": "admin",
+	}
+
+	redacted := configs.Redacted()
+	if len(redacted) != len(expected) {
+		t.Errorf("expected %d keys, got %d: %v", len(expected), len(redacted), redacted)
+	}
+
+	for key, value := range expected {
+		if got, ok := redacted[key]; !ok || got != value {
+			t.Errorf("Redacted()[%s] = %q, expected %q", key, got, value)
+		}
+	}
+
+	for _, secret := range []string{"spotify-secret", "state", "signing-key"} {
+		for key, value := range redacted {
+			if value == secret {
+				t.Errorf("secret leaked under %s", key)
+			}
+		}
+	}
+}
+
+func TestReadConfigHiddenPlaylists(t *testing.T) {
+	env := completeEnv()
+	env["SPOTIFY_HIDDEN_PLAYLISTS"] = " playlist1, ,,\tplaylist2 ,"
+	defer setEnv(env)()
+
+	configs, err := (&AppConfig{}).ReadConfig()
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	hidden := configs.SPOTIFY_HIDDEN_PLAYLISTS
+	if len(hidden) != 2 || hidden[0] != "playlist1" || hidden[1] != "playlist2" {
+		t.Errorf("expected [playlist1 playlist2], got %q", hidden)
+	}
+}
+
+func TestSplitListBlank(t *testing.T) {
+	for _, value := range []string{"", " ", ",", " , ,\t"} {
+		if list := splitList(value); list == nil || len(list) != 0 {
+			t.Errorf("splitList(%q) expected an empty list, got %q", value, list)
+		}
+	}
+}
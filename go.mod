@@ -15,7 +15,7 @@ require (
 	github.com/niemeyer/pretty v0.0.0-20200227124842-a10e7caefd8e // indirect
 	github.com/s1s1ty/go-mysql-crud v0.0.0-20181126175725-0d81d1289c43
 	github.com/satori/go.uuid v1.2.0
-	github.com/zmb3/spotify v0.0.0-20200112163645-71a4c67d18db
+	github.com/zmb3/spotify v1.0.0
 	golang.org/x/net v0.0.0-20200501053045-e0ff5e5a1de5
 	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d
 	golang.org/x/sys v0.0.0-20200501145240-bc7a7d42d5c3 // indirect
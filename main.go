@@ -17,12 +17,11 @@ import (
 func main() {	
 	config := &config.AppConfig{}
 	configs, err:= config.ReadConfig()
-	db := config.ConnectToDB()
-
 	if err != nil{
 		panic(fmt.Sprintf("Startup issues: \n%s", err.Error()))
 	}
-	
+
+	db := config.ConnectToDB()
 	defer db.Close()
 
 	spotifyService := &services.SpotifyService{Config: configs}
@@ -6,13 +6,14 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/nnajiabraham/spotube/config"
 	"github.com/nnajiabraham/spotube/models"
 	"github.com/nnajiabraham/spotube/services"
+	"github.com/zmb3/spotify"
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
 )
@@ -34,6 +35,8 @@ type claimKeyType string
 
 const claimKey claimKeyType = "claims"
 
+var errSpotifyNotConnected = errors.New("Spotify account not connected")
+
 func (h *AppHandler) verifyJWT(next http.Handler) http.Handler {
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Content-Type", "application/json")
@@ -78,6 +81,27 @@ func (h *AppHandler) verifyJWT(next http.Handler) http.Handler {
     })
 }
 
+// requireAdmin only lets through users whose spotify id is listed in ADMIN_SPOTIFY_IDS, it must run after verifyJWT
+func (h *AppHandler) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims := r.Context().Value(claimKey).(services.Claims)
+
+		for _, adminID := range h.Config.ADMIN_SPOTIFY_IDS {
+			if claims.SpotifyId == adminID {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		log.Printf("Forbidden admin route for spotify id: %s ", claims.SpotifyId)
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(response{
+			StatusCode: http.StatusForbidden,
+			Data: "Forbidden",
+		})
+	})
+}
+
 func contentJSONMiddleware(next http.Handler) http.Handler {
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
         w.Header().Add("Content-Type", "application/json")
@@ -109,13 +133,18 @@ func (h *AppHandler) RegisterRoutes(router *mux.Router) {
 	router.HandleFunc("/", h.homeHandler)
 	router.HandleFunc("/spotify-login", h.spotifyLogin)
 	router.HandleFunc("/youtube-login", h.youtubeLogin)
-	router.HandleFunc("/google-callback", h.youtubeLogin)
+	router.HandleFunc("/google-callback", h.googleCallback)
 	router.HandleFunc("/spotify-callback", h.spotifyCallback)
 
 	protectedRoutes := router.NewRoute().Subrouter()
 	protectedRoutes.Use(h.verifyJWT)
 	protectedRoutes.HandleFunc("/spotify-playlist", responseHandler(h.getSpotifyPlaylist)).Methods("GET")
 	protectedRoutes.HandleFunc("/user", responseHandler(h.getUserProfile))
+
+	adminRoutes := protectedRoutes.NewRoute().Subrouter()
+	adminRoutes.Use(h.requireAdmin)
+	adminRoutes.HandleFunc("/diagnostics", responseHandler(h.getDiagnostics)).Methods("GET")
+	adminRoutes.HandleFunc("/search", responseHandler(h.searchTracks)).Methods("GET")
 }
 
 //npm install -g localtunnel
@@ -125,6 +154,16 @@ func (h *AppHandler) RegisterRoutes(router *mux.Router) {
 // lt -h "http://viewshd.com" --port 2580 --subdomain nnajiabraham
 
 
+// loginTimeout answers a login callback whose token exchange timed out. The user has no session cookie
+// yet, so redirecting to a protected page would only show Unauthorized.
+func loginTimeout(w http.ResponseWriter, provider string) {
+	w.WriteHeader(http.StatusGatewayTimeout)
+	json.NewEncoder(w).Encode(response{
+		StatusCode: http.StatusGatewayTimeout,
+		Data: fmt.Sprintf("%s login timed out, please try logging in again", provider),
+	})
+}
+
 func (h *AppHandler) homeHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "No place like home")
 }
@@ -141,6 +180,11 @@ func (h *AppHandler) googleCallback(w http.ResponseWriter, r *http.Request) {
 
 	log.Println("FREAKING CALLBACK HIT")
 	service, err := h.YoutubeService.GetYoutubeService(r)
+	if err == services.ErrOauthExchangeTimeout {
+		log.Printf("Youtube/Google login callback: %s ",err.Error())
+		loginTimeout(w, "Google")
+		return
+	}
 	if err != nil {
 		log.Printf("Youtube/Google login callback: %s ",err.Error())
 		http.Redirect(w, r, "/user", http.StatusMovedPermanently)
@@ -179,6 +223,11 @@ func (h *AppHandler) spotifyLogin(w http.ResponseWriter, r *http.Request) {
 func (h *AppHandler) spotifyCallback(w http.ResponseWriter, r *http.Request){
 
 	client, err:= h.SpotifyService.GetSpotifyClientToken(r)
+	if err == services.ErrOauthExchangeTimeout {
+		log.Printf("Spotify login callback: %s ",err.Error())
+		loginTimeout(w, "Spotify")
+		return
+	}
 	if err != nil {
 		log.Printf("Spotify login callback: %s ",err.Error())
 		http.Redirect(w, r, "/user", http.StatusMovedPermanently)
@@ -223,27 +272,103 @@ func (h *AppHandler) getSpotifyPlaylist(w http.ResponseWriter, r *http.Request)
 	user := h.UserService.FetchUser(claims.SpotifyId)
 
 	userOauthToken, err := createSpotifyUserToken(user)
+	if err == errSpotifyNotConnected {
+		return nil, http.StatusUnauthorized, err
+	}
 	if err!=nil {
 		log.Printf("Unable to get token: %s ",err.Error())
 		return nil, http.StatusInternalServerError, errors.New("Internal Server Error")
 	}
 
-	userPlaylist, err:= h.SpotifyService.GetUserPlaylists(userOauthToken)
+	userPlaylist, err:= h.SpotifyService.GetUserPlaylists(userOauthToken, h.persistSpotifyToken(user))
 
 	if err!=nil {
 		log.Printf("Unable to get user Playlists: %s ",err.Error())
 		return nil, http.StatusInternalServerError, errors.New("Internal Server Error")
 	}
 
+	userPlaylist = h.withoutHiddenPlaylists(userPlaylist)
+
+	// followed playlists can be read but not modified, let clients ask for only the ones they own
+	if r.URL.Query().Get("owned_only") == "true" {
+		userPlaylist = ownedPlaylists(userPlaylist, user.SpotifyID)
+	}
+
 	return userPlaylist, http.StatusOK, nil
 }
 
+// ownedPlaylists keeps only the playlists owned by the given spotify user
+func ownedPlaylists(playlists []spotify.SimplePlaylist, spotifyID string) []spotify.SimplePlaylist {
+	ownedPlaylist := []spotify.SimplePlaylist{}
+	for _, playlist := range playlists{
+		if playlist.Owner.ID == spotifyID {
+			ownedPlaylist = append(ownedPlaylist, playlist)
+		}
+	}
+	return ownedPlaylist
+}
+
+// withoutHiddenPlaylists drops playlists the operator hid via SPOTIFY_HIDDEN_PLAYLISTS
+func (h *AppHandler) withoutHiddenPlaylists(playlists []spotify.SimplePlaylist) []spotify.SimplePlaylist {
+	if len(h.Config.SPOTIFY_HIDDEN_PLAYLISTS) == 0 {
+		return playlists
+	}
+
+	hidden := map[string]bool{}
+	for _, playlistID := range h.Config.SPOTIFY_HIDDEN_PLAYLISTS{
+		hidden[playlistID] = true
+	}
+
+	visiblePlaylist := []spotify.SimplePlaylist{}
+	for _, playlist := range playlists{
+		if !hidden[playlist.ID.String()] {
+			visiblePlaylist = append(visiblePlaylist, playlist)
+		}
+	}
+	return visiblePlaylist
+}
+
+func (h *AppHandler) searchTracks(w http.ResponseWriter, r *http.Request) (interface{}, int, error) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		return nil, http.StatusBadRequest, errors.New("Missing search query q")
+	}
+
+	// youtube tokens are not stored per user yet, so only spotify can be searched
+	if service := r.URL.Query().Get("service"); service != "spotify" {
+		return nil, http.StatusBadRequest, fmt.Errorf("Unsupported search service: %q", service)
+	}
+
+	claims := r.Context().Value(claimKey).(services.Claims)
+	user := h.UserService.FetchUser(claims.SpotifyId)
+
+	userOauthToken, err := createSpotifyUserToken(user)
+	if err == errSpotifyNotConnected {
+		return nil, http.StatusUnauthorized, err
+	}
+	if err!=nil {
+		log.Printf("Unable to get token: %s ",err.Error())
+		return nil, http.StatusInternalServerError, errors.New("Internal Server Error")
+	}
+
+	candidates, err := h.SpotifyService.SearchTracks(userOauthToken, query, h.persistSpotifyToken(user))
+	if err!=nil {
+		log.Printf("Unable to search spotify tracks: %s ",err.Error())
+		return nil, http.StatusInternalServerError, errors.New("Internal Server Error")
+	}
+
+	return candidates, http.StatusOK, nil
+}
+
 func (h *AppHandler) getUserProfile(w http.ResponseWriter, r *http.Request) (interface{}, int, error){
 
 	claims := r.Context().Value(claimKey).(services.Claims)
 	user := h.UserService.FetchUser(claims.SpotifyId)
 
 	userOauthToken, err := createSpotifyUserToken(user)
+	if err == errSpotifyNotConnected {
+		return nil, http.StatusUnauthorized, err
+	}
 	if err!=nil {
 		log.Printf("Unable to get token: %s ",err.Error())
 		return nil, http.StatusInternalServerError, errors.New("Internal Server Error")
@@ -258,7 +383,7 @@ func (h *AppHandler) getUserProfile(w http.ResponseWriter, r *http.Request) (int
 		}, http.StatusOK, nil
 	}
 
-	client:= h.SpotifyService.GetSpotifyAuth().NewClient(userOauthToken)
+	client:= h.SpotifyService.NewUserClient(userOauthToken, h.persistSpotifyToken(user))
 	userSpotifyProfile, err := client.CurrentUser()
 
 	if err!=nil {
@@ -266,7 +391,14 @@ func (h *AppHandler) getUserProfile(w http.ResponseWriter, r *http.Request) (int
 		return nil, http.StatusInternalServerError, errors.New("Internal Server Error")
 	}
 
-	updatedUser, err := h.UserService.UpdateUser(userSpotifyProfile, userOauthToken)
+	// the expired token was refreshed (and already saved) during CurrentUser, pass that one on and not the stale one
+	refreshedOauthToken, err := client.Token()
+	if err!=nil {
+		log.Printf("Unable to read refreshed token: %s ",err.Error())
+		return nil, http.StatusInternalServerError, errors.New("Internal Server Error")
+	}
+
+	updatedUser, err := h.UserService.UpdateUser(userSpotifyProfile, refreshedOauthToken)
 	
 	if err!=nil {
 		log.Printf("Err Updating User: %s ",err.Error())
@@ -281,8 +413,48 @@ func (h *AppHandler) getUserProfile(w http.ResponseWriter, r *http.Request) (int
 	}, http.StatusOK, nil
 }
 
+func (h *AppHandler) getDiagnostics(w http.ResponseWriter, r *http.Request) (interface{}, int, error) {
+	claims := r.Context().Value(claimKey).(services.Claims)
+	user := h.UserService.FetchUser(claims.SpotifyId)
+
+	userCount, err := h.UserService.CountUsers()
+	if err!=nil {
+		log.Printf("Unable to count users: %s ",err.Error())
+		return nil, http.StatusInternalServerError, errors.New("Internal Server Error")
+	}
+
+	_, err = createSpotifyUserToken(user)
+
+	// never include tokens or secrets here, this is meant to be pasted into issues
+	return map[string]interface{}{
+		"version": config.Version,
+		"config": h.Config.Redacted(),
+		"counts": map[string]int{
+			"users": userCount,
+		},
+		"connections": map[string]bool{
+			"spotify": err == nil,
+		},
+	}, http.StatusOK, nil
+}
+
+// persistSpotifyToken saves tokens as soon as they are refreshed during a request, so the next request doesn't
+// refresh again and a rotated refresh token isn't lost when the api call itself fails
+func (h *AppHandler) persistSpotifyToken(user *models.User) services.TokenRefreshHandler {
+	return func(token *oauth2.Token) {
+		if err := h.UserService.UpdateUserToken(user, token); err != nil {
+			log.Printf("Unable to persist refreshed spotify token: %s ",err.Error())
+		}
+	}
+}
+
 func createSpotifyUserToken(user *models.User) (*oauth2.Token, error){
-	tokenExpTime, err:= strconv.ParseInt(user.SpotifyTokenExpiry, 10, 64)
+	// a missing user or a partially written record has no usable tokens at all
+	if strings.TrimSpace(user.SpotifyToken) == "" && strings.TrimSpace(user.SpotifyRefreshToken) == "" {
+		return nil, errSpotifyNotConnected
+	}
+
+	tokenExpTime, err:= services.ParseTokenExpiry(user.SpotifyTokenExpiry)
 
 	if err != nil {
 		log.Printf("Error parsing time to oauth2token type")
@@ -290,7 +462,7 @@ func createSpotifyUserToken(user *models.User) (*oauth2.Token, error){
 	}
 	
 	return &oauth2.Token{
-		Expiry: time.Unix(tokenExpTime, 0),
+		Expiry: tokenExpTime,
 		TokenType: user.SpotifyTokenType,
 		AccessToken: user.SpotifyToken,
 		RefreshToken: user.SpotifyRefreshToken,
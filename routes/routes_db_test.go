@@ -0,0 +1,240 @@
+// +build cgo
+
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/nnajiabraham/spotube/config"
+	"github.com/nnajiabraham/spotube/models"
+	"github.com/nnajiabraham/spotube/services"
+)
+
+// these tests need a user store, the sqlite driver behind it requires cgo
+
+// redirectTransport sends every request, whatever its host, to the test server
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (t *redirectTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme = t.target.Scheme
+	r.URL.Host = t.target.Host
+	r.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// testHandler wires an AppHandler to an in memory user store and a fake spotify answering with spotifyHandler
+func testHandler(t *testing.T, configs *config.Configs, spotifyHandler http.Handler) (*AppHandler, *mux.Router, func()) {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("unable to open test database: %s", err)
+	}
+	db.AutoMigrate(&models.User{})
+
+	server := httptest.NewServer(spotifyHandler)
+	target, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("unable to parse test server url: %s", err)
+	}
+
+	h := &AppHandler{
+		Config: configs,
+		UserService: &services.UserService{DB: db, Config: configs},
+		TokenService: &services.TokenService{Config: configs},
+		SpotifyService: &services.SpotifyService{
+			Config: configs,
+			HTTPClient: &http.Client{Transport: &redirectTransport{target: target}},
+		},
+	}
+	router := mux.NewRouter().StrictSlash(true)
+	h.RegisterRoutes(router)
+
+	return h, router, func() {
+		server.Close()
+		db.Close()
+	}
+}
+
+// authenticatedRequest builds a request carrying a valid session cookie for user
+func authenticatedRequest(t *testing.T, h *AppHandler, user *models.User, target string) *http.Request {
+	jwtString, err := h.TokenService.CreateToken(user, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("unable to create token: %s", err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	r.AddCookie(&http.Cookie{Name: "token", Value: jwtString})
+	return r
+}
+
+func writeJSON(w http.ResponseWriter, status int, body string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write([]byte(body))
+}
+
+func TestRefreshedTokenIsStoredWhenPlaylistCallFails(t *testing.T) {
+	spotifyMux := http.NewServeMux()
+	spotifyMux.HandleFunc("/api/token", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, `{"access_token":"new-access","refresh_token":"new-refresh","token_type":"Bearer","expires_in":3600}`)
+	})
+	spotifyMux.HandleFunc("/v1/me/playlists", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusInternalServerError, `{"error":{"status":500,"message":"Server error"}}`)
+	})
+
+	configs := &config.Configs{JWT_SIGNING_KEY: "signing-key", SPOTIFY_ID: "client-id", SPOTIFY_SECRET: "client-secret"}
+	h, router, done := testHandler(t, configs, spotifyMux)
+	defer done()
+
+	user := &models.User{
+		UserID: "user-1",
+		SpotifyID: "listener",
+		Email: "listener@example.com",
+		SpotifyToken: "old-access",
+		SpotifyRefreshToken: "old-refresh",
+		SpotifyTokenType: "Bearer",
+		SpotifyTokenExpiry: services.FormatTokenExpiry(time.Now().Add(-time.Minute)),
+	}
+	h.UserService.DB.Create(user)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authenticatedRequest(t, h, user, "/spotify-playlist"))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected the failing playlist call to answer 500, got %d", w.Code)
+	}
+
+	stored := h.UserService.FetchUser("listener")
+	if stored.SpotifyToken != "new-access" || stored.SpotifyRefreshToken != "new-refresh" {
+		t.Errorf("expected the refreshed token to be stored, got %q %q", stored.SpotifyToken, stored.SpotifyRefreshToken)
+	}
+	expiry, err := services.ParseTokenExpiry(stored.SpotifyTokenExpiry)
+	if err != nil || !expiry.After(time.Now()) {
+		t.Errorf("expected a future token expiry, got %q", stored.SpotifyTokenExpiry)
+	}
+}
+
+func TestSearchRouteReturnsCandidates(t *testing.T) {
+	spotifyMux := http.NewServeMux()
+	spotifyMux.HandleFunc("/v1/search", func(w http.ResponseWriter, r *http.Request) {
+		if q, searchType := r.URL.Query().Get("q"), r.URL.Query().Get("type"); q != "never gonna" || searchType != "track" {
+			t.Errorf("unexpected search q=%q type=%q", q, searchType)
+		}
+		writeJSON(w, http.StatusOK, `{"tracks":{"items":[
+			{"id":"4cOdK2wGLETKBW3PvgPWqT","name":"Never Gonna Give You Up","duration_ms":213573,"artists":[{"name":"Rick Astley"}]},
+			{"id":"7GhIk7Il098yCjg4BQjzvb","name":"Never Gonna Give You Up (Cover)","duration_ms":180000,"artists":[{"name":"Band A"},{"name":"Band B"}]}
+		]}}`)
+	})
+
+	configs := &config.Configs{JWT_SIGNING_KEY: "signing-key", ADMIN_SPOTIFY_IDS: []string{"operator"}}
+	h, router, done := testHandler(t, configs, spotifyMux)
+	defer done()
+
+	user := &models.User{
+		UserID: "user-1",
+		SpotifyID: "operator",
+		Email: "operator@example.com",
+		SpotifyToken: "access",
+		SpotifyRefreshToken: "refresh",
+		SpotifyTokenType: "Bearer",
+		SpotifyTokenExpiry: services.FormatTokenExpiry(time.Now().Add(time.Hour)),
+	}
+	h.UserService.DB.Create(user)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authenticatedRequest(t, h, user, "/search?service=spotify&q=never+gonna"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	body := struct {
+		StatusCode int `json:"statusCode"`
+		Data []services.SearchCandidate `json:"response"`
+	}{}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("unable to decode response: %s", err)
+	}
+
+	expected := []services.SearchCandidate{
+		{ID: "4cOdK2wGLETKBW3PvgPWqT", Title: "Never Gonna Give You Up", Artist: "Rick Astley", DurationMs: 213573},
+		{ID: "7GhIk7Il098yCjg4BQjzvb", Title: "Never Gonna Give You Up (Cover)", Artist: "Band A, Band B", DurationMs: 180000},
+	}
+	if !reflect.DeepEqual(body.Data, expected) {
+		t.Errorf("expected %+v, got %+v", expected, body.Data)
+	}
+}
+
+func TestDiagnosticsRouteForAdmin(t *testing.T) {
+	configs := &config.Configs{
+		SPOTIFY_ID: "client-id",
+		SPOTIFY_SECRET: "spotify-secret",
+		TOKEN_STATE: "state-secret",
+		JWT_SIGNING_KEY: "signing-key",
+		GOOGLE_CLIENT_SECRET: "google-secret",
+		ADMIN_SPOTIFY_IDS: []string{"operator"},
+	}
+	h, router, done := testHandler(t, configs, http.NotFoundHandler())
+	defer done()
+
+	admin := &models.User{
+		UserID: "user-1",
+		SpotifyID: "operator",
+		Email: "operator@example.com",
+		SpotifyToken: "admin-access-token",
+		SpotifyRefreshToken: "admin-refresh-token",
+		SpotifyTokenType: "Bearer",
+		SpotifyTokenExpiry: services.FormatTokenExpiry(time.Now().Add(time.Hour)),
+	}
+	h.UserService.DB.Create(admin)
+	h.UserService.DB.Create(&models.User{UserID: "user-2", SpotifyID: "listener", Email: "listener@example.com"})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authenticatedRequest(t, h, admin, "/diagnostics"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	for _, secret := range []string{"spotify-secret", "state-secret", "signing-key", "google-secret", "admin-access-token", "admin-refresh-token"} {
+		if strings.Contains(w.Body.String(), secret) {
+			t.Errorf("diagnostics leaked %q", secret)
+		}
+	}
+
+	body := struct {
+		Data struct {
+			Version string `json:"version"`
+			Config map[string]string `json:"config"`
+			Counts map[string]int `json:"counts"`
+			Connections map[string]bool `json:"connections"`
+		} `json:"response"`
+	}{}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("unable to decode response: %s", err)
+	}
+
+	diagnostics := body.Data
+	if diagnostics.Version != config.Version {
+		t.Errorf("expected version %q, got %q", config.Version, diagnostics.Version)
+	}
+	if diagnostics.Config["SPOTIFY_ID"] != "client-id" || diagnostics.Config["SPOTIFY_SECRET"] != "********" ||
+		diagnostics.Config["JWT_SIGNING_KEY"] != "********" || diagnostics.Config["GOOGLE_CLIENT_SECRET"] != "********" {
+		t.Errorf("unexpected config section %v", diagnostics.Config)
+	}
+	if diagnostics.Counts["users"] != 2 {
+		t.Errorf("expected 2 users, got %v", diagnostics.Counts)
+	}
+	if !diagnostics.Connections["spotify"] {
+		t.Errorf("expected a spotify connection, got %v", diagnostics.Connections)
+	}
+}
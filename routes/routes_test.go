@@ -0,0 +1,207 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/nnajiabraham/spotube/config"
+	"github.com/nnajiabraham/spotube/models"
+	"github.com/nnajiabraham/spotube/services"
+	"github.com/zmb3/spotify"
+)
+
+func TestSearchTracksRejectsInvalidQueries(t *testing.T) {
+	h := &AppHandler{}
+
+	urls := []string{
+		"/search?service=spotify",
+		"/search?service=spotify&q=%20%20",
+		"/search?service=youtube&q=never+gonna",
+		"/search?q=never+gonna",
+	}
+
+	for _, url := range urls {
+		r := httptest.NewRequest(http.MethodGet, url, nil)
+		data, status, err := h.searchTracks(httptest.NewRecorder(), r)
+
+		if status != http.StatusBadRequest || err == nil || data != nil {
+			t.Errorf("%s: expected 400 with an error, got %d %v %v", url, status, data, err)
+		}
+	}
+}
+
+func TestCreateSpotifyUserTokenNotConnected(t *testing.T) {
+	users := map[string]*models.User{
+		"missing user": {},
+		"empty tokens": {SpotifyTokenExpiry: "1588998615"},
+		"whitespace tokens": {
+			SpotifyToken: "  ",
+			SpotifyRefreshToken: "\t\n",
+			SpotifyTokenExpiry: "1588998615",
+		},
+	}
+
+	for name, user := range users {
+		if _, err := createSpotifyUserToken(user); err != errSpotifyNotConnected {
+			t.Errorf("%s: expected errSpotifyNotConnected, got %v", name, err)
+		}
+	}
+}
+
+func TestCreateSpotifyUserTokenRefreshOnly(t *testing.T) {
+	// an expired access token can still be refreshed, so the user counts as connected
+	user := &models.User{
+		SpotifyRefreshToken: "refresh-token",
+		SpotifyTokenType: "Bearer",
+		SpotifyTokenExpiry: "1588998615",
+	}
+
+	token, err := createSpotifyUserToken(user)
+	if err != nil {
+		t.Fatalf("expected a token, got error %v", err)
+	}
+	if token.RefreshToken != "refresh-token" || token.AccessToken != "" || token.Expiry.Unix() != 1588998615 {
+		t.Errorf("unexpected token %+v", token)
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	h := &AppHandler{Config: &config.Configs{ADMIN_SPOTIFY_IDS: []string{"operator"}}}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	statuses := map[string]int{
+		"operator": http.StatusTeapot,
+		"listener": http.StatusForbidden,
+		"": http.StatusForbidden,
+	}
+
+	for spotifyID, expected := range statuses {
+		r := httptest.NewRequest(http.MethodGet, "/diagnostics", nil)
+		r = r.WithContext(context.WithValue(r.Context(), claimKey, services.Claims{SpotifyId: spotifyID}))
+		w := httptest.NewRecorder()
+
+		h.requireAdmin(next).ServeHTTP(w, r)
+
+		if w.Code != expected {
+			t.Errorf("spotify id %q: expected %d, got %d", spotifyID, expected, w.Code)
+		}
+	}
+}
+
+func TestAdminRoutesAreAdminOnly(t *testing.T) {
+	configs := &config.Configs{JWT_SIGNING_KEY: "signing-key", ADMIN_SPOTIFY_IDS: []string{"operator"}}
+	h := &AppHandler{Config: configs, TokenService: &services.TokenService{Config: configs}}
+	router := mux.NewRouter().StrictSlash(true)
+	h.RegisterRoutes(router)
+
+	jwtString, err := h.TokenService.CreateToken(&models.User{SpotifyID: "listener"}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("unable to create token: %s", err)
+	}
+
+	for _, path := range []string{"/diagnostics", "/search?service=spotify&q=never+gonna"} {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.AddCookie(&http.Cookie{Name: "token", Value: jwtString})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s non admin user: expected 403, got %d", path, w.Code)
+		}
+
+		r = httptest.NewRequest(http.MethodGet, path, nil)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s no token: expected 401, got %d", path, w.Code)
+		}
+	}
+}
+
+func TestSpotifyCallbackTimeout(t *testing.T) {
+	slowTokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(200 * time.Millisecond):
+		}
+	}))
+	defer slowTokenServer.Close()
+
+	configs := &config.Configs{TOKEN_STATE: "state", OAUTH_EXCHANGE_TIMEOUT: 20 * time.Millisecond}
+	h := &AppHandler{
+		Config: configs,
+		SpotifyService: &services.SpotifyService{Config: configs, TokenURL: slowTokenServer.URL},
+	}
+	router := mux.NewRouter().StrictSlash(true)
+	h.RegisterRoutes(router)
+
+	r := httptest.NewRequest(http.MethodGet, "/spotify-callback?code=code&state=state", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected 504, got %d", w.Code)
+	}
+	if location := w.Header().Get("Location"); location != "" {
+		t.Errorf("expected no redirect, got Location %s", location)
+	}
+
+	body := response{}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("unable to decode response: %s", err)
+	}
+	if body.StatusCode != http.StatusGatewayTimeout || body.Data != "Spotify login timed out, please try logging in again" {
+		t.Errorf("unexpected response %+v", body)
+	}
+}
+
+func playlistIDs(playlists []spotify.SimplePlaylist) []string {
+	ids := []string{}
+	for _, playlist := range playlists {
+		ids = append(ids, playlist.ID.String())
+	}
+	return ids
+}
+
+func TestOwnedPlaylists(t *testing.T) {
+	playlists := []spotify.SimplePlaylist{
+		{ID: "owned1", Owner: spotify.User{ID: "listener"}},
+		{ID: "followed", Owner: spotify.User{ID: "spotify"}},
+		{ID: "owned2", Owner: spotify.User{ID: "listener"}},
+		{ID: "collaborative", Owner: spotify.User{ID: "friend"}, Collaborative: true},
+	}
+
+	owned := playlistIDs(ownedPlaylists(playlists, "listener"))
+	if len(owned) != 2 || owned[0] != "owned1" || owned[1] != "owned2" {
+		t.Errorf("expected [owned1 owned2], got %v", owned)
+	}
+
+	if owned := ownedPlaylists(playlists, "nobody"); owned == nil || len(owned) != 0 {
+		t.Errorf("expected an empty, non-nil list, got %#v", owned)
+	}
+}
+
+func TestWithoutHiddenPlaylists(t *testing.T) {
+	playlists := []spotify.SimplePlaylist{
+		{ID: "discover-weekly"},
+		{ID: "road-trip"},
+		{ID: "release-radar"},
+	}
+
+	h := &AppHandler{Config: &config.Configs{SPOTIFY_HIDDEN_PLAYLISTS: []string{"discover-weekly", "release-radar"}}}
+	visible := playlistIDs(h.withoutHiddenPlaylists(playlists))
+	if len(visible) != 1 || visible[0] != "road-trip" {
+		t.Errorf("expected [road-trip], got %v", visible)
+	}
+
+	h = &AppHandler{Config: &config.Configs{}}
+	if visible := h.withoutHiddenPlaylists(playlists); len(visible) != 3 {
+		t.Errorf("expected every playlist without hidden ids, got %v", playlistIDs(visible))
+	}
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+//ErrOauthExchangeTimeout is returned when a provider doesn't answer the code exchange in time
+var ErrOauthExchangeTimeout = errors.New("oauth: token exchange timed out")
+
+// authCodeFromRequest pulls the authorization code out of a provider callback request after checking the state
+func authCodeFromRequest(provider string, state string, r *http.Request) (string, error) {
+	values := r.URL.Query()
+	if e := values.Get("error"); e != "" {
+		return "", errors.New(provider + ": auth failed - " + e)
+	}
+	code := values.Get("code")
+	if code == "" {
+		return "", errors.New(provider + ": didn't get access code")
+	}
+	actualState := values.Get("state")
+	if actualState != state {
+		return "", errors.New(provider + ": redirect state parameter doesn't match")
+	}
+	return code, nil
+}
+
+// exchangeToken exchanges an authorization code for a token, bounding each attempt by timeout
+// and retrying once when the failure looks transient (timeouts, refused or reset connections, provider 5xx)
+func exchangeToken(ctx context.Context, conf *oauth2.Config, code string, timeout time.Duration) (*oauth2.Token, error) {
+	var token *oauth2.Token
+	var err error
+
+	for attempt := 0; attempt < 2; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		token, err = conf.Exchange(attemptCtx, code)
+		cancel()
+
+		if err == nil || ctx.Err() != nil || !isTransientExchangeError(err) {
+			break
+		}
+	}
+
+	if err != nil && isTimeoutError(err) {
+		return nil, ErrOauthExchangeTimeout
+	}
+
+	return token, err
+}
+
+func isTransientExchangeError(err error) bool {
+	if isTimeoutError(err) {
+		return true
+	}
+
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		return retrieveErr.Response != nil && retrieveErr.Response.StatusCode >= http.StatusInternalServerError
+	}
+
+	// other transport failures (bad urls, tls errors, dns misconfiguration) fail the same way again
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET)
+}
+
+func isTimeoutError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/nnajiabraham/spotube/config"
+	"golang.org/x/oauth2"
+)
+
+// tokenServer serves the oauth token endpoint, answering each attempt with the matching response
+func tokenServer(t *testing.T, attempts *int32, responses ...func(w http.ResponseWriter, r *http.Request)) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempt := int(atomic.AddInt32(attempts, 1)) - 1
+		if attempt >= len(responses) {
+			t.Errorf("unexpected token exchange attempt %d", attempt+1)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		responses[attempt](w, r)
+	}))
+	return server
+}
+
+func slowToken(w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-r.Context().Done():
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func okToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"access_token":"access-token","refresh_token":"refresh-token","token_type":"Bearer","expires_in":3600}`))
+}
+
+func statusToken(status int) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(`{"error":"server_error"}`))
+	}
+}
+
+func exchangeConfig(tokenURL string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID: "client-id",
+		ClientSecret: "client-secret",
+		Endpoint: oauth2.Endpoint{TokenURL: tokenURL, AuthStyle: oauth2.AuthStyleInParams},
+	}
+}
+
+func TestExchangeTokenTimesOutAfterRetry(t *testing.T) {
+	var attempts int32
+	server := tokenServer(t, &attempts, slowToken, slowToken)
+	defer server.Close()
+
+	_, err := exchangeToken(context.Background(), exchangeConfig(server.URL), "code", 50*time.Millisecond)
+
+	if err != ErrOauthExchangeTimeout {
+		t.Errorf("expected ErrOauthExchangeTimeout, got %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("expected 2 attempts, got %d", attempts)
+	}
+}
+
+func TestExchangeTokenRetriesTransientErrors(t *testing.T) {
+	responses := map[string]func(w http.ResponseWriter, r *http.Request){
+		"timeout": slowToken,
+		"bad gateway": statusToken(http.StatusBadGateway),
+	}
+
+	for name, first := range responses {
+		var attempts int32
+		server := tokenServer(t, &attempts, first, okToken)
+		defer server.Close()
+
+		token, err := exchangeToken(context.Background(), exchangeConfig(server.URL), "code", 50*time.Millisecond)
+
+		if err != nil || token.AccessToken != "access-token" {
+			t.Errorf("%s: expected the retry to succeed, got %v %v", name, token, err)
+		}
+		if attempts != 2 {
+			t.Errorf("%s: expected 2 attempts, got %d", name, attempts)
+		}
+	}
+}
+
+func TestExchangeTokenDoesNotRetryClientErrors(t *testing.T) {
+	var attempts int32
+	server := tokenServer(t, &attempts, statusToken(http.StatusBadRequest))
+	defer server.Close()
+
+	_, err := exchangeToken(context.Background(), exchangeConfig(server.URL), "code", 50*time.Millisecond)
+
+	if _, ok := err.(*oauth2.RetrieveError); !ok {
+		t.Errorf("expected the provider's RetrieveError, got %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("expected 1 attempt, got %d", attempts)
+	}
+}
+
+func TestExchangeTokenDoesNotRetryTLSErrors(t *testing.T) {
+	var connections int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(okToken))
+	server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&connections, 1)
+		}
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	// the default client doesn't trust the test server's certificate, a retry would fail the same way
+	_, err := exchangeToken(context.Background(), exchangeConfig(server.URL), "code", 50*time.Millisecond)
+
+	if err == nil || err == ErrOauthExchangeTimeout {
+		t.Errorf("expected the certificate error, got %v", err)
+	}
+	if connections != 1 {
+		t.Errorf("expected 1 connection, got %d", connections)
+	}
+}
+
+func TestIsTransientExchangeError(t *testing.T) {
+	connectionErr := func(errno syscall.Errno) error {
+		return &url.Error{Op: "Post", URL: "https://accounts.spotify.com/api/token", Err: &net.OpError{
+			Op: "dial",
+			Net: "tcp",
+			Err: os.NewSyscallError("connect", errno),
+		}}
+	}
+
+	errs := map[string]struct {
+		err error
+		transient bool
+	}{
+		"connection refused": {connectionErr(syscall.ECONNREFUSED), true},
+		"connection reset": {connectionErr(syscall.ECONNRESET), true},
+		"deadline exceeded": {&url.Error{Op: "Post", URL: "https://accounts.spotify.com/api/token", Err: context.DeadlineExceeded}, true},
+		"bad url": {&url.Error{Op: "Post", URL: "accounts.spotify.com/api/token", Err: errors.New("unsupported protocol scheme \"\"")}, false},
+		"unknown host": {&url.Error{Op: "Post", URL: "https://accounts.invalid/api/token", Err: &net.DNSError{Err: "no such host", Name: "accounts.invalid", IsNotFound: true}}, false},
+	}
+
+	for name, test := range errs {
+		if transient := isTransientExchangeError(test.err); transient != test.transient {
+			t.Errorf("%s: expected transient %t, got %t", name, test.transient, transient)
+		}
+	}
+}
+
+func TestGetSpotifyClientTokenTimesOut(t *testing.T) {
+	var attempts int32
+	server := tokenServer(t, &attempts, slowToken, slowToken)
+	defer server.Close()
+
+	s := &SpotifyService{
+		Config: &config.Configs{TOKEN_STATE: "state", OAUTH_EXCHANGE_TIMEOUT: 50 * time.Millisecond},
+		TokenURL: server.URL,
+	}
+	r := httptest.NewRequest(http.MethodGet, "/spotify-callback?code=code&state=state", nil)
+
+	if _, err := s.GetSpotifyClientToken(r); err != ErrOauthExchangeTimeout {
+		t.Errorf("expected ErrOauthExchangeTimeout, got %v", err)
+	}
+}
+
+func TestAuthCodeFromRequest(t *testing.T) {
+	urls := map[string]bool{
+		"/callback?code=code&state=state": true,
+		"/callback?code=code&state=other": false,
+		"/callback?state=state": false,
+		"/callback?error=access_denied&state=state": false,
+	}
+
+	for url, valid := range urls {
+		code, err := authCodeFromRequest("spotify", "state", httptest.NewRequest(http.MethodGet, url, nil))
+		if valid && (err != nil || code != "code") {
+			t.Errorf("%s: expected code, got %q %v", url, code, err)
+		}
+		if !valid && err == nil {
+			t.Errorf("%s: expected an error", url)
+		}
+	}
+}
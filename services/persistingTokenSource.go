@@ -0,0 +1,38 @@
+package services
+
+import (
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+//TokenRefreshHandler receives a user token as soon as the oauth2 transport refreshed it
+type TokenRefreshHandler func(token *oauth2.Token)
+
+// persistingTokenSource wraps a token source and hands every newly issued token to onTokenRefresh,
+// so a refreshed (and possibly rotated) token is saved even when the api call using it fails
+type persistingTokenSource struct {
+	mu             sync.Mutex
+	source         oauth2.TokenSource
+	current        *oauth2.Token
+	onTokenRefresh TokenRefreshHandler
+}
+
+func (p *persistingTokenSource) Token() (*oauth2.Token, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	token, err := p.source.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.current == nil || token.AccessToken != p.current.AccessToken || token.RefreshToken != p.current.RefreshToken {
+		p.current = token
+		if p.onTokenRefresh != nil {
+			p.onTokenRefresh(token)
+		}
+	}
+
+	return token, nil
+}
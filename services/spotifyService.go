@@ -1,10 +1,13 @@
 package services
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
 	"math"
 	"net/http"
+	"strings"
 
 	"github.com/nnajiabraham/spotube/config"
 	"github.com/zmb3/spotify"
@@ -14,7 +17,13 @@ import (
 // SpotifyService  ....
 type SpotifyService struct{
 	Config *config.Configs
+	// TokenURL overrides spotify's token endpoint for the login code exchange, spotify.TokenURL when empty
+	TokenURL string
+	// HTTPClient overrides the client used to reach spotify, one with HTTP/2 disabled when nil
+	HTTPClient *http.Client
 	spotifyAuth *spotify.Authenticator
+	spotifyOauthConfig *oauth2.Config
+	spotifyHTTPClient *http.Client
 }
 
 //SpotifyClientToken struct wraps the spotify library for custom usage
@@ -23,6 +32,14 @@ type SpotifyClientToken struct{
 	UserToken *oauth2.Token
 }
 
+//SearchCandidate is a normalized track search result
+type SearchCandidate struct{
+	ID string `json:"id"`
+	Title string `json:"title"`
+	Artist string `json:"artist"`
+	DurationMs int `json:"durationMs"`
+}
+
 //GetSpotifyAuth returns a spotify auth that can be used to generate a client
 func (s *SpotifyService) GetSpotifyAuth() *spotify.Authenticator{
 	if s.spotifyAuth != nil {
@@ -31,12 +48,56 @@ func (s *SpotifyService) GetSpotifyAuth() *spotify.Authenticator{
 
 	scopes					:= fmt.Sprintf("%s %s %s %s", spotify.ScopeUserReadPrivate, spotify.ScopeUserReadEmail, spotify.ScopePlaylistReadPrivate, spotify.ScopePlaylistReadCollaborative)
 	redirectURICallback		:= "http://nnajiabraham.viewshd.com/spotify-callback" 
-	auth := spotify.NewAuthenticator(redirectURICallback, scopes)
-	auth.SetAuthInfo(s.Config.SPOTIFY_ID, s.Config.SPOTIFY_SECRET)
+
+	tokenURL := spotify.TokenURL
+	if s.TokenURL != "" {
+		tokenURL = s.TokenURL
+	}
+
+	// The authenticator keeps its oauth2 config private, but the login code exchange needs its own context
+	// to be bounded by a timeout. So this duplicate config is built first and the authenticator is created
+	// from the same values, keeping the two from drifting apart.
+	s.spotifyOauthConfig = &oauth2.Config{
+		ClientID: s.Config.SPOTIFY_ID,
+		ClientSecret: s.Config.SPOTIFY_SECRET,
+		RedirectURL: redirectURICallback,
+		Scopes: []string{scopes},
+		Endpoint: oauth2.Endpoint{
+			AuthURL: spotify.AuthURL,
+			TokenURL: tokenURL,
+		},
+	}
+
+	auth := spotify.NewAuthenticator(s.spotifyOauthConfig.RedirectURL, s.spotifyOauthConfig.Scopes...)
+	auth.SetAuthInfo(s.spotifyOauthConfig.ClientID, s.spotifyOauthConfig.ClientSecret)
 	s.spotifyAuth=&auth
+
+	// same client the authenticator uses, HTTP/2 disabled, see: https://github.com/zmb3/spotify/issues/20
+	s.spotifyHTTPClient = s.HTTPClient
+	if s.spotifyHTTPClient == nil {
+		s.spotifyHTTPClient = &http.Client{
+			Transport: &http.Transport{
+				TLSNextProto: map[string]func(authority string, c *tls.Conn) http.RoundTripper{},
+			},
+		}
+	}
 	return &auth
 }
 
+//NewUserClient returns a spotify client for the user token. Tokens the oauth2 transport refreshes are
+//handed to onTokenRefresh right away, whatever happens to the api call that triggered the refresh.
+func (s *SpotifyService) NewUserClient(userOauthToken *oauth2.Token, onTokenRefresh TokenRefreshHandler) spotify.Client{
+	s.GetSpotifyAuth()
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, s.spotifyHTTPClient)
+
+	source := &persistingTokenSource{
+		source: s.spotifyOauthConfig.TokenSource(ctx, userOauthToken),
+		current: userOauthToken,
+		onTokenRefresh: onTokenRefresh,
+	}
+	return spotify.NewClient(oauth2.NewClient(ctx, source))
+}
+
 //GetSpotifyAuthLoginURL returns a spotify login url for the client
 func (s *SpotifyService) GetSpotifyAuthLoginURL() string{
 	url := s.GetSpotifyAuth().AuthURL(s.Config.TOKEN_STATE)
@@ -45,21 +106,28 @@ func (s *SpotifyService) GetSpotifyAuthLoginURL() string{
 
 //GetSpotifyClientToken returns a spotify clientToken from URL during the code-token exchange
 func (s *SpotifyService) GetSpotifyClientToken(r *http.Request)(*SpotifyClientToken, error){
-	token, err := s.GetSpotifyAuth().Token(s.Config.TOKEN_STATE, r)
+	code, err := authCodeFromRequest("spotify", s.Config.TOKEN_STATE, r)
+	if err != nil {
+        return nil, err
+	}
+
+	s.GetSpotifyAuth()
+	ctx := context.WithValue(r.Context(), oauth2.HTTPClient, s.spotifyHTTPClient)
+	token, err := exchangeToken(ctx, s.spotifyOauthConfig, code, s.Config.OAUTH_EXCHANGE_TIMEOUT)
 	if err != nil {
         return nil, err
 	}
 
 	// use the token to get an authenticated client
-	client := s.GetSpotifyAuth().NewClient(token)
+	client := s.NewUserClient(token, nil)
 	clientToken := &SpotifyClientToken{SpotifyClient: client, UserToken:token}
 	return clientToken, nil
 }
 
 //GetUserPlaylists paginates and returns a slice of all playlists for authenticated user
-func (s *SpotifyService) GetUserPlaylists(userOauthToken *oauth2.Token)([]spotify.SimplePlaylist, error){
+func (s *SpotifyService) GetUserPlaylists(userOauthToken *oauth2.Token, onTokenRefresh TokenRefreshHandler)([]spotify.SimplePlaylist, error){
 
-	client:= s.GetSpotifyAuth().NewClient(userOauthToken)
+	client:= s.NewUserClient(userOauthToken, onTokenRefresh)
 
 	offset, limit := 0, 20
 	
@@ -102,4 +170,41 @@ func (s *SpotifyService) GetUserPlaylists(userOauthToken *oauth2.Token)([]spotif
 	}
 
 	return userPlaylist, nil
-}
\ No newline at end of file
+}
+
+//SearchTracks searches spotify tracks as the authenticated user and returns normalized candidates
+func (s *SpotifyService) SearchTracks(userOauthToken *oauth2.Token, query string, onTokenRefresh TokenRefreshHandler)([]SearchCandidate, error){
+
+	client:= s.NewUserClient(userOauthToken, onTokenRefresh)
+
+	result, err := client.Search(query, spotify.SearchTypeTrack)
+	if err != nil{
+		return nil, err
+	}
+
+	if result.Tracks == nil {
+		return []SearchCandidate{}, nil
+	}
+
+	return searchCandidates(result.Tracks.Tracks), nil
+}
+
+// searchCandidates normalizes spotify tracks into the candidate shape returned by search
+func searchCandidates(tracks []spotify.FullTrack) []SearchCandidate{
+	candidates := []SearchCandidate{}
+	for _, track := range tracks{
+		artists := []string{}
+		for _, artist := range track.Artists{
+			artists = append(artists, artist.Name)
+		}
+
+		candidates = append(candidates, SearchCandidate{
+			ID: track.ID.String(),
+			Title: track.Name,
+			Artist: strings.Join(artists, ", "),
+			DurationMs: track.Duration,
+		})
+	}
+
+	return candidates
+}
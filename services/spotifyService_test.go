@@ -0,0 +1,198 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nnajiabraham/spotube/config"
+	"github.com/zmb3/spotify"
+	"golang.org/x/oauth2"
+)
+
+// redirectTransport sends every request, whatever its host, to the test server
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (t *redirectTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme = t.target.Scheme
+	r.URL.Host = t.target.Host
+	r.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// testSpotifyService returns a SpotifyService whose accounts and api requests are served by handler
+func testSpotifyService(t *testing.T, handler http.Handler) (*SpotifyService, *httptest.Server) {
+	server := httptest.NewServer(handler)
+	target, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("unable to parse test server url: %s", err)
+	}
+
+	return &SpotifyService{
+		Config: &config.Configs{SPOTIFY_ID: "client-id", SPOTIFY_SECRET: "client-secret"},
+		HTTPClient: &http.Client{Transport: &redirectTransport{target: target}},
+	}, server
+}
+
+func writeJSON(w http.ResponseWriter, status int, body string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write([]byte(body))
+}
+
+const refreshedTokenBody = `{"access_token":"new-access","refresh_token":"new-refresh","token_type":"Bearer","expires_in":3600}`
+
+func TestSearchCandidates(t *testing.T) {
+	tracks := []spotify.FullTrack{
+		{SimpleTrack: spotify.SimpleTrack{
+			ID:       "4uLU6hMCjMI75M1A2tKUQC",
+			Name:     "Never Gonna Give You Up",
+			Artists:  []spotify.SimpleArtist{{Name: "Rick Astley"}},
+			Duration: 213573,
+		}},
+		{SimpleTrack: spotify.SimpleTrack{
+			ID:       "0nrRP2bk19rLc0orkWPQk2",
+			Name:     "Wake Me Up",
+			Artists:  []spotify.SimpleArtist{{Name: "Avicii"}, {Name: "Aloe Blacc"}},
+			Duration: 247427,
+		}},
+		{SimpleTrack: spotify.SimpleTrack{ID: "7ouMYWpwJ422jRcDASZB7P", Name: "No Artist"}},
+	}
+
+	expected := []SearchCandidate{
+		{ID: "4uLU6hMCjMI75M1A2tKUQC", Title: "Never Gonna Give You Up", Artist: "Rick Astley", DurationMs: 213573},
+		{ID: "0nrRP2bk19rLc0orkWPQk2", Title: "Wake Me Up", Artist: "Avicii, Aloe Blacc", DurationMs: 247427},
+		{ID: "7ouMYWpwJ422jRcDASZB7P", Title: "No Artist", Artist: "", DurationMs: 0},
+	}
+
+	if candidates := searchCandidates(tracks); !reflect.DeepEqual(candidates, expected) {
+		t.Errorf("searchCandidates() = %+v, expected %+v", candidates, expected)
+	}
+}
+
+func TestSearchCandidatesEmpty(t *testing.T) {
+	candidates := searchCandidates(nil)
+	if candidates == nil || len(candidates) != 0 {
+		t.Errorf("expected an empty, non-nil slice, got %#v", candidates)
+	}
+}
+
+func TestSearchCandidateJSONShape(t *testing.T) {
+	encoded, err := json.Marshal(SearchCandidate{ID: "id", Title: "title", Artist: "artist", DurationMs: 1000})
+	if err != nil {
+		t.Fatalf("unable to encode candidate: %s", err)
+	}
+
+	expected := `{"id":"id","title":"title","artist":"artist","durationMs":1000}`
+	if string(encoded) != expected {
+		t.Errorf("encoded candidate = %s, expected %s", encoded, expected)
+	}
+}
+
+func expiredToken() *oauth2.Token {
+	return &oauth2.Token{
+		AccessToken: "old-access",
+		RefreshToken: "old-refresh",
+		TokenType: "Bearer",
+		Expiry: time.Now().Add(-time.Minute),
+	}
+}
+
+func TestRefreshedTokenIsHandedOverWhenCallFails(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/token", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, refreshedTokenBody)
+	})
+	mux.HandleFunc("/v1/me/playlists", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer new-access" {
+			t.Errorf("expected the refreshed access token, got %q", r.Header.Get("Authorization"))
+		}
+		writeJSON(w, http.StatusInternalServerError, `{"error":{"status":500,"message":"Server error"}}`)
+	})
+	s, server := testSpotifyService(t, mux)
+	defer server.Close()
+
+	received := []*oauth2.Token{}
+	_, err := s.GetUserPlaylists(expiredToken(), func(token *oauth2.Token) {
+		received = append(received, token)
+	})
+
+	if err == nil {
+		t.Fatalf("expected the playlist call to fail")
+	}
+	if len(received) != 1 || received[0].AccessToken != "new-access" || received[0].RefreshToken != "new-refresh" {
+		t.Errorf("expected the refreshed token to be handed over once, got %v", received)
+	}
+}
+
+func TestRefreshedTokenIsHandedOverOnce(t *testing.T) {
+	refreshes := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/token", func(w http.ResponseWriter, r *http.Request) {
+		refreshes++
+		writeJSON(w, http.StatusOK, refreshedTokenBody)
+	})
+	mux.HandleFunc("/v1/search", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, `{"tracks":{"items":[]}}`)
+	})
+	s, server := testSpotifyService(t, mux)
+	defer server.Close()
+
+	received := 0
+	client := s.NewUserClient(expiredToken(), func(token *oauth2.Token) {
+		received++
+	})
+	for i := 0; i < 3; i++ {
+		if _, err := client.Search("never gonna", spotify.SearchTypeTrack); err != nil {
+			t.Fatalf("unexpected search error %s", err)
+		}
+	}
+
+	if refreshes != 1 || received != 1 {
+		t.Errorf("expected one refresh handed over once, got %d refreshes and %d notifications", refreshes, received)
+	}
+}
+
+func TestValidTokenIsNotHandedOver(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/token", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected token refresh")
+		writeJSON(w, http.StatusBadRequest, `{"error":"invalid_grant"}`)
+	})
+	mux.HandleFunc("/v1/search", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, `{"tracks":{"items":[]}}`)
+	})
+	s, server := testSpotifyService(t, mux)
+	defer server.Close()
+
+	validToken := &oauth2.Token{AccessToken: "access", RefreshToken: "refresh", TokenType: "Bearer", Expiry: time.Now().Add(time.Hour)}
+	_, err := s.SearchTracks(validToken, "never gonna", func(token *oauth2.Token) {
+		t.Errorf("unexpected token notification %v", token)
+	})
+	if err != nil {
+		t.Errorf("unexpected search error %s", err)
+	}
+}
+
+func TestFailedRefreshIsNotHandedOver(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/token", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusBadRequest, `{"error":"invalid_grant","error_description":"Refresh token revoked"}`)
+	})
+	s, server := testSpotifyService(t, mux)
+	defer server.Close()
+
+	_, err := s.SearchTracks(expiredToken(), "never gonna", func(token *oauth2.Token) {
+		t.Errorf("unexpected token notification %v", token)
+	})
+	if err == nil {
+		t.Errorf("expected the revoked refresh token to fail the search")
+	}
+}
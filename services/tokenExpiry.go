@@ -0,0 +1,21 @@
+package services
+
+import (
+	"strconv"
+	"time"
+)
+
+//FormatTokenExpiry encodes an oauth token expiry the way it is stored on the user record (unix seconds)
+func FormatTokenExpiry(expiry time.Time) string {
+	return strconv.FormatInt(expiry.Unix(), 10)
+}
+
+//ParseTokenExpiry decodes a stored token expiry back into a time
+func ParseTokenExpiry(expiry string) (time.Time, error) {
+	seconds, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(seconds, 0), nil
+}
@@ -0,0 +1,43 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenExpiryRoundTrip(t *testing.T) {
+	expiries := []time.Time{
+		time.Date(2020, 5, 9, 14, 30, 15, 0, time.UTC),
+		time.Date(2020, 5, 9, 9, 30, 15, 0, time.FixedZone("EST", -5*60*60)),
+		time.Unix(0, 0),
+	}
+
+	for _, expiry := range expiries {
+		parsed, err := ParseTokenExpiry(FormatTokenExpiry(expiry))
+		if err != nil {
+			t.Fatalf("ParseTokenExpiry(%v) returned error: %s", expiry, err)
+		}
+		if !parsed.Equal(expiry) {
+			t.Errorf("round trip of %v gave %v", expiry, parsed)
+		}
+	}
+}
+
+func TestTokenExpiryRoundTripZero(t *testing.T) {
+	// a zero expiry means the token never expires and must stay zero once stored
+	parsed, err := ParseTokenExpiry(FormatTokenExpiry(time.Time{}))
+	if err != nil {
+		t.Fatalf("ParseTokenExpiry returned error: %s", err)
+	}
+	if !parsed.IsZero() {
+		t.Errorf("expected zero expiry, got %v", parsed)
+	}
+}
+
+func TestParseTokenExpiryInvalid(t *testing.T) {
+	for _, expiry := range []string{"", "abc", "1588998615.5", "2020-05-09T14:30:15Z"} {
+		if _, err := ParseTokenExpiry(expiry); err == nil {
+			t.Errorf("ParseTokenExpiry(%q) expected an error", expiry)
+		}
+	}
+}
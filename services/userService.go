@@ -3,7 +3,6 @@ package services
 import (
 	"errors"
 	"fmt"
-	"strconv"
 
 	"github.com/gofrs/uuid"
 	"github.com/jinzhu/gorm"
@@ -28,6 +27,13 @@ func (s *UserService) FetchUser(userID string) (*models.User) {
 	return registeredUser
 }
 
+//CountUsers returns the number of registered users
+func (s *UserService) CountUsers() (int, error) {
+	count := 0
+	err := s.DB.Model(&models.User{}).Count(&count).Error
+	return count, err
+}
+
 //FetchOrCreateUser fetches a user record if exist or creates one
 func (s *UserService) FetchOrCreateUser(user *spotify.PrivateUser, token *oauth2.Token) (*models.User, error) {
 
@@ -39,10 +45,7 @@ func (s *UserService) FetchOrCreateUser(user *spotify.PrivateUser, token *oauth2
 		Email: user.Email}).First(registeredUser)
 
 	if (models.User{}) != *registeredUser {
-		registeredUser.SpotifyToken=token.AccessToken
-		registeredUser.SpotifyRefreshToken=token.RefreshToken
-		registeredUser.SpotifyTokenType=token.TokenType
-		registeredUser.SpotifyTokenExpiry=strconv.FormatInt(token.Expiry.Unix(), 10)
+		setSpotifyToken(registeredUser, token)
 		s.DB.Save(registeredUser)
 
 		return registeredUser, nil
@@ -65,7 +68,7 @@ func (s *UserService) FetchOrCreateUser(user *spotify.PrivateUser, token *oauth2
 		SpotifyToken: token.AccessToken, 
 		SpotifyRefreshToken: token.RefreshToken,
 		SpotifyTokenType: token.TokenType,
-		SpotifyTokenExpiry: strconv.FormatInt(token.Expiry.Unix(), 10)}
+		SpotifyTokenExpiry: FormatTokenExpiry(token.Expiry)}
 
 	s.DB.Create(newUser)
 
@@ -89,11 +92,21 @@ func (s *UserService) UpdateUser(user *spotify.PrivateUser, token *oauth2.Token)
 		return nil,err
 	}	
 
-	registeredUser.SpotifyToken=token.AccessToken
-	registeredUser.SpotifyRefreshToken=token.RefreshToken
-	registeredUser.SpotifyTokenType=token.TokenType
-	registeredUser.SpotifyTokenExpiry=strconv.FormatInt(token.Expiry.Unix(), 10)
+	setSpotifyToken(registeredUser, token)
 	s.DB.Save(registeredUser)
 		
 	return registeredUser, nil
 }
+
+//UpdateUserToken persists a refreshed spotify token on an existing user record
+func (s *UserService) UpdateUserToken(user *models.User, token *oauth2.Token) error {
+	setSpotifyToken(user, token)
+	return s.DB.Save(user).Error
+}
+
+func setSpotifyToken(user *models.User, token *oauth2.Token) {
+	user.SpotifyToken=token.AccessToken
+	user.SpotifyRefreshToken=token.RefreshToken
+	user.SpotifyTokenType=token.TokenType
+	user.SpotifyTokenExpiry=FormatTokenExpiry(token.Expiry)
+}
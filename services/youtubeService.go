@@ -1,7 +1,6 @@
 package services
 
 import (
-	"errors"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -37,8 +36,6 @@ func (s *YoutubeService) getGoogleClientSecretFile() []byte{
 	log.Fatalf("Unable to read client secret file: %v", err)
 	}
 
-	log.Printf("googleClientSecretFile %s", googleClientSecretFile)
-
 	return googleClientSecretFile
 }
 
@@ -54,7 +51,6 @@ func (s *YoutubeService) getGoogleConfigAuth() *oauth2.Config{
 	if err != nil {
 		log.Fatalf("Unable to parse client secret file to config: %v", err)
 	}
-	log.Printf("googleConfig %s", googleConfig)
 	
 	return googleConfig
 }
@@ -79,21 +75,12 @@ func (s *YoutubeService) GetYoutubeService(r *http.Request) (*youtube.Service, e
 	return service, nil
 }
 
-// Token pulls an authorization code from an HTTP request and attempts to exchange
-// it for an access token.  The standard use case is to call Token from the handler
-// that handles requests to your application's redirect URL.
+// token pulls an authorization code from the google callback request and exchanges it
+// for an access token, bounded by the configured exchange timeout.
 func (s *YoutubeService) token(state string, r *http.Request) (*oauth2.Token, error) {
-	values := r.URL.Query()
-	if e := values.Get("error"); e != "" {
-		return nil, errors.New("spotify: auth failed - " + e)
-	}
-	code := values.Get("code")
-	if code == "" {
-		return nil, errors.New("spotify: didn't get access code")
-	}
-	actualState := values.Get("state")
-	if actualState != state {
-		return nil, errors.New("spotify: redirect state parameter doesn't match")
+	code, err := authCodeFromRequest("google", state, r)
+	if err != nil {
+		return nil, err
 	}
-	return s.getGoogleConfigAuth().Exchange(r.Context(), code)
+	return exchangeToken(r.Context(), s.getGoogleConfigAuth(), code, s.Config.OAUTH_EXCHANGE_TIMEOUT)
 }